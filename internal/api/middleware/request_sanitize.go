@@ -1,7 +1,9 @@
 // Package middleware provides HTTP middleware components for the CLI Proxy API server.
 // This file contains the request sanitization middleware that cleans up incompatible
-// fields in OpenAI/Anthropic format requests before forwarding to Gemini API.
-// Implements "Triple-Layer Sanitization" strategy for maximum compatibility.
+// fields in OpenAI/Anthropic format requests before forwarding to an upstream backend.
+// The exact fields and transforms applied are declared by a SanitizeProfile (see
+// sanitize_profile.go), selected per route or per upstream via a ProfileSelector. Every
+// field touched is recorded in a SanitizationReport (see sanitize_report.go) for debugging.
 package middleware
 
 import (
@@ -9,64 +11,18 @@ import (
 	"fmt"
 	"io"
 	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
 	"github.com/tidwall/gjson"
 	"github.com/tidwall/sjson"
-	log "github.com/sirupsen/logrus"
 )
 
-var unsupportedRootFields = []string{
-	"cache_control",
-	"citations",
-	"container",
-	"metadata",
-	"service_tier",
-	"logprobs",
-	"top_logprobs",
-	"logit_bias",
-	"parallel_tool_calls",
-}
-
-var unsupportedSchemaFields = []string{
-	"additionalProperties",
-	"$schema",
-	"pattern",
-	"exclusiveMinimum",
-	"exclusiveMaximum",
-	"minItems",
-	"maxItems",
-	"minLength",
-	"maxLength",
-	"default",
-	"format",
-	"examples",
-	"$id",
-	"$ref",
-	"$defs",
-	"definitions",
-	"allOf",
-	"anyOf",
-	"oneOf",
-	"not",
-	"if",
-	"then",
-	"else",
-	"dependentSchemas",
-	"dependentRequired",
-	"propertyNames",
-	"unevaluatedProperties",
-	"unevaluatedItems",
-	"contentMediaType",
-	"contentEncoding",
-}
-
-var unsupportedToolChoiceValues = map[string]bool{
-	"validated": true,
-	"required":  true,
-}
-
-func RequestSanitizeMiddleware() gin.HandlerFunc {
+// RequestSanitizeMiddleware sanitizes POST request bodies before they are forwarded
+// upstream, using selector to pick a SanitizeProfile for the request path. Pass
+// StaticProfileSelector(GeminiProfile()) to apply the built-in Gemini profile to every route.
+func RequestSanitizeMiddleware(selector ProfileSelector) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		if c.Request.Method != "POST" {
 			c.Next()
@@ -84,19 +40,36 @@ func RequestSanitizeMiddleware() gin.HandlerFunc {
 			return
 		}
 
+		profile := selector(path)
+		if profile == nil {
+			c.Next()
+			return
+		}
+
 		bodyBytes, err := io.ReadAll(c.Request.Body)
 		if err != nil {
 			c.Next()
 			return
 		}
 
-		sanitizedBody, stats := sanitizeRequestBody(bodyBytes)
+		sanitizedBody, report := sanitizeRequestBodyDispatch(bodyBytes, profile)
+		report.Path = path
+		report.Profile = profile.Name
 
-		if stats.totalRemoved > 0 {
-			log.Debugf("request_sanitized: path=%s removed=%d flattened=%d merged_system=%t",
-				path, stats.totalRemoved, stats.flattenedMessages, stats.mergedSystem)
+		if len(report.Events) > 0 {
+			log.Debugf("request_sanitized: path=%s profile=%s removed=%d flattened=%d merged_system=%t",
+				path, profile.Name, len(report.Events), report.FlattenedMessages, report.MergedSystem)
+			recentSanitizeReports.push(report)
 		}
 
+		writeSanitizeDebugHeader(c, &report)
+
+		c.Set(sanitizeContextKey, &requestSanitizeContext{
+			Profile:          profile,
+			Dialect:          detectDialect(path),
+			MergedSystemText: report.mergedSystemText,
+		})
+
 		c.Request.Body = io.NopCloser(bytes.NewBuffer(sanitizedBody))
 		c.Request.ContentLength = int64(len(sanitizedBody))
 
@@ -104,12 +77,6 @@ func RequestSanitizeMiddleware() gin.HandlerFunc {
 	}
 }
 
-type sanitizeStats struct {
-	totalRemoved      int
-	flattenedMessages int
-	mergedSystem      bool
-}
-
 func shouldSanitizeRequest(path string) bool {
 	return strings.Contains(path, "/chat/completions") ||
 		strings.Contains(path, "/completions") ||
@@ -117,127 +84,142 @@ func shouldSanitizeRequest(path string) bool {
 		strings.Contains(path, "/messages")
 }
 
-func sanitizeRequestBody(body []byte) ([]byte, sanitizeStats) {
-	stats := sanitizeStats{}
+// sanitizeRequestBodyDispatch picks between the streaming single-pass rewriter and the
+// gjson/sjson pipeline based on body size, falling back to the latter if the streaming
+// rewriter can't handle the body (e.g. it isn't a JSON object). Profiles that delete
+// schema keywords blindly (SchemaFieldsToRemove without RewriteSchemas) always use the
+// pipeline, since the streaming rewriter only implements the schema rewriter.
+func sanitizeRequestBodyDispatch(body []byte, profile *SanitizeProfile) ([]byte, SanitizationReport) {
+	blindSchemaDeletion := !profile.RewriteSchemas && len(profile.SchemaFieldsToRemove) > 0
+	if len(body) >= streamingThreshold(profile) && !blindSchemaDeletion {
+		if result, report, ok := sanitizeRequestBodyStreaming(body, profile); ok {
+			return result, report
+		}
+	}
+	return sanitizeRequestBody(body, profile)
+}
+
+func sanitizeRequestBody(body []byte, profile *SanitizeProfile) ([]byte, SanitizationReport) {
+	report := SanitizationReport{Timestamp: time.Now()}
 
 	if len(body) == 0 {
-		return body, stats
+		return body, report
 	}
 
 	if !gjson.ValidBytes(body) {
-		return body, stats
+		return body, report
 	}
 
 	result := body
 
-	for _, field := range unsupportedRootFields {
-		if gjson.GetBytes(result, field).Exists() {
+	for _, field := range profile.RootFieldsToRemove {
+		if v := gjson.GetBytes(result, field); v.Exists() {
 			result, _ = sjson.DeleteBytes(result, field)
-			stats.totalRemoved++
+			report.record(field, "unsupported_root_field", v.Raw)
 		}
 	}
 
-	result, tcRemoved := sanitizeToolChoice(result)
-	stats.totalRemoved += tcRemoved
+	result = sanitizeToolChoice(result, profile, &report)
 
-	result, cacheRemoved := removeCacheControlFromMessages(result)
-	stats.totalRemoved += cacheRemoved
+	if profile.RemoveCacheControl {
+		result = removeCacheControlFromMessages(result, &report)
+	}
 
-	result, toolRemoved := sanitizeToolSchemas(result)
-	stats.totalRemoved += toolRemoved
+	if profile.RewriteSchemas || len(profile.SchemaFieldsToRemove) > 0 {
+		result = sanitizeToolSchemas(result, profile, &report)
+	}
 
-	result, toolUseConverted := convertClaudeToolUseToOpenAI(result)
-	stats.totalRemoved += toolUseConverted
+	if profile.ConvertClaudeToolUse {
+		result = convertClaudeToolUseToOpenAI(result, &report)
+	}
 
-	result, flattenCount := flattenMessageContent(result)
-	stats.flattenedMessages = flattenCount
+	if profile.FlattenMessageContent {
+		result = flattenMessageContent(result, &report)
+	}
 
-	result, emptyFixed := fixEmptyAssistantMessages(result)
-	stats.totalRemoved += emptyFixed
+	if profile.FixEmptyAssistantMessages {
+		result = fixEmptyAssistantMessages(result, &report)
+	}
 
-	model := gjson.GetBytes(result, "model").String()
-	if strings.Contains(strings.ToLower(model), "thinking") {
-		var merged bool
-		result, merged = mergeSystemToFirstUserMessage(result)
-		stats.mergedSystem = merged
-		if merged {
-			stats.totalRemoved++
+	if profile.MergeSystemOnModelMatch {
+		model := gjson.GetBytes(result, "model").String()
+		if profile.ModelMatch == "" || strings.Contains(strings.ToLower(model), strings.ToLower(profile.ModelMatch)) {
+			result = mergeSystemToFirstUserMessage(result, &report)
 		}
 	}
 
-	result, systemRemoved := sanitizeSystemField(result)
-	stats.totalRemoved += systemRemoved
+	if profile.RemoveCacheControl {
+		result = sanitizeSystemField(result, &report)
+	}
 
-	return result, stats
+	return result, report
 }
 
-func sanitizeToolChoice(body []byte) ([]byte, int) {
+func sanitizeToolChoice(body []byte, profile *SanitizeProfile, report *SanitizationReport) []byte {
 	toolChoice := gjson.GetBytes(body, "tool_choice")
 	if !toolChoice.Exists() {
-		return body, 0
+		return body
 	}
 
 	result := body
-	removed := 0
 
 	if toolChoice.Type == gjson.String {
 		val := toolChoice.String()
-		if unsupportedToolChoiceValues[val] {
+		if profile.isUnsupportedToolChoice(val) {
 			result, _ = sjson.SetBytes(result, "tool_choice", "auto")
-			removed++
+			report.record("tool_choice", "unsupported_tool_choice_value", toolChoice.Raw)
 		}
 	} else if toolChoice.IsObject() {
 		tcType := toolChoice.Get("type").String()
-		if tcType == "auto" || tcType == "" || tcType == "any" {
+		if profile.isUnsupportedToolChoice(tcType) || tcType == "auto" || tcType == "" || tcType == "any" {
 			result, _ = sjson.SetBytes(result, "tool_choice", "auto")
-			removed++
+			report.record("tool_choice", "normalized_tool_choice", toolChoice.Raw)
 		} else if tcType == "function" || tcType == "tool" {
 			result, _ = sjson.DeleteBytes(result, "tool_choice")
-			removed++
+			report.record("tool_choice", "unsupported_tool_choice_type", toolChoice.Raw)
 		}
 	}
 
-	return result, removed
+	return result
 }
 
-func removeCacheControlFromMessages(body []byte) ([]byte, int) {
+func removeCacheControlFromMessages(body []byte, report *SanitizationReport) []byte {
 	messages := gjson.GetBytes(body, "messages")
 	if !messages.IsArray() {
-		return body, 0
+		return body
 	}
 
-	removed := 0
 	result := body
 
 	for i, msg := range messages.Array() {
-		if msg.Get("cache_control").Exists() {
+		if cc := msg.Get("cache_control"); cc.Exists() {
 			path := "messages." + itoa(i) + ".cache_control"
 			result, _ = sjson.DeleteBytes(result, path)
-			removed++
+			report.record(path, "cache_control_unsupported", cc.Raw)
 		}
 
-		if msg.Get("name").Exists() {
+		if name := msg.Get("name"); name.Exists() {
 			path := "messages." + itoa(i) + ".name"
 			result, _ = sjson.DeleteBytes(result, path)
-			removed++
+			report.record(path, "name_unsupported", name.Raw)
 		}
 
 		content := msg.Get("content")
 		if content.IsArray() {
 			for j, item := range content.Array() {
-				if item.Get("cache_control").Exists() {
+				if cc := item.Get("cache_control"); cc.Exists() {
 					path := "messages." + itoa(i) + ".content." + itoa(j) + ".cache_control"
 					result, _ = sjson.DeleteBytes(result, path)
-					removed++
+					report.record(path, "cache_control_unsupported", cc.Raw)
 				}
 
 				innerContent := item.Get("content")
 				if innerContent.IsArray() {
 					for k, innerItem := range innerContent.Array() {
-						if innerItem.Get("cache_control").Exists() {
+						if cc := innerItem.Get("cache_control"); cc.Exists() {
 							path := "messages." + itoa(i) + ".content." + itoa(j) + ".content." + itoa(k) + ".cache_control"
 							result, _ = sjson.DeleteBytes(result, path)
-							removed++
+							report.record(path, "cache_control_unsupported", cc.Raw)
 						}
 					}
 				}
@@ -245,18 +227,16 @@ func removeCacheControlFromMessages(body []byte) ([]byte, int) {
 		}
 	}
 
-	return result, removed
+	return result
 }
 
-
-func convertClaudeToolUseToOpenAI(body []byte) ([]byte, int) {
+func convertClaudeToolUseToOpenAI(body []byte, report *SanitizationReport) []byte {
 	messages := gjson.GetBytes(body, "messages")
 	if !messages.IsArray() {
-		return body, 0
+		return body
 	}
 
 	result := body
-	converted := 0
 
 	for i, msg := range messages.Array() {
 		role := msg.Get("role").String()
@@ -310,7 +290,7 @@ func convertClaudeToolUseToOpenAI(body []byte) ([]byte, int) {
 				result, _ = sjson.SetBytes(result, tcPath+".function.name", fn["name"])
 				result, _ = sjson.SetRawBytes(result, tcPath+".function.arguments", []byte(fn["arguments"].(string)))
 			}
-			converted++
+			report.record(msgPath+".content", "claude_tool_use_converted", content.Raw)
 		}
 	}
 
@@ -337,13 +317,13 @@ func convertClaudeToolUseToOpenAI(body []byte) ([]byte, int) {
 				result, _ = sjson.SetBytes(result, msgPath+".role", "tool")
 				result, _ = sjson.SetBytes(result, msgPath+".tool_call_id", toolUseId)
 				result, _ = sjson.SetBytes(result, msgPath+".content", resultContent)
-				converted++
+				report.record(msgPath, "claude_tool_result_converted", part.Raw)
 				break
 			}
 		}
 	}
 
-	return result, converted
+	return result
 }
 
 func extractToolResultContent(content gjson.Result) string {
@@ -375,14 +355,13 @@ func extractToolResultContent(content gjson.Result) string {
 	return ""
 }
 
-func flattenMessageContent(body []byte) ([]byte, int) {
+func flattenMessageContent(body []byte, report *SanitizationReport) []byte {
 	messages := gjson.GetBytes(body, "messages")
 	if !messages.IsArray() {
-		return body, 0
+		return body
 	}
 
 	result := body
-	flattened := 0
 
 	for i, msg := range messages.Array() {
 		content := msg.Get("content")
@@ -404,21 +383,21 @@ func flattenMessageContent(body []byte) ([]byte, int) {
 			flattenedContent := strings.Join(textParts, "")
 			path := "messages." + itoa(i) + ".content"
 			result, _ = sjson.SetBytes(result, path, flattenedContent)
-			flattened++
+			report.record(path, "content_flattened", content.Raw)
+			report.FlattenedMessages++
 		}
 	}
 
-	return result, flattened
+	return result
 }
 
-func fixEmptyAssistantMessages(body []byte) ([]byte, int) {
+func fixEmptyAssistantMessages(body []byte, report *SanitizationReport) []byte {
 	messages := gjson.GetBytes(body, "messages")
 	if !messages.IsArray() {
-		return body, 0
+		return body
 	}
 
 	result := body
-	fixed := 0
 	indicesToRemove := []int{}
 
 	for i, msg := range messages.Array() {
@@ -436,7 +415,7 @@ func fixEmptyAssistantMessages(body []byte) ([]byte, int) {
 			if hasToolCalls {
 				path := "messages." + itoa(i) + ".content"
 				result, _ = sjson.SetBytes(result, path, " ")
-				fixed++
+				report.record(path, "empty_assistant_content_padded", content.Raw)
 			} else {
 				indicesToRemove = append(indicesToRemove, i)
 			}
@@ -447,16 +426,16 @@ func fixEmptyAssistantMessages(body []byte) ([]byte, int) {
 		idx := indicesToRemove[j]
 		path := "messages." + itoa(idx)
 		result, _ = sjson.DeleteBytes(result, path)
-		fixed++
+		report.record(path, "empty_assistant_message_removed", "")
 	}
 
-	return result, fixed
+	return result
 }
 
-func mergeSystemToFirstUserMessage(body []byte) ([]byte, bool) {
+func mergeSystemToFirstUserMessage(body []byte, report *SanitizationReport) []byte {
 	system := gjson.GetBytes(body, "system")
 	if !system.Exists() {
-		return body, false
+		return body
 	}
 
 	var systemText string
@@ -479,13 +458,19 @@ func mergeSystemToFirstUserMessage(body []byte) ([]byte, bool) {
 
 	if systemText == "" {
 		result, _ := sjson.DeleteBytes(body, "system")
-		return result, true
+		report.record("system", "system_merged_into_first_user_message", system.Raw)
+		report.MergedSystem = true
+		return result
 	}
 
+	report.mergedSystemText = systemText
+
 	messages := gjson.GetBytes(body, "messages")
 	if !messages.IsArray() || len(messages.Array()) == 0 {
 		result, _ := sjson.DeleteBytes(body, "system")
-		return result, true
+		report.record("system", "system_merged_into_first_user_message", system.Raw)
+		report.MergedSystem = true
+		return result
 	}
 
 	result := body
@@ -521,27 +506,33 @@ func mergeSystemToFirstUserMessage(body []byte) ([]byte, bool) {
 	}
 
 	result, _ = sjson.DeleteBytes(result, "system")
-	return result, true
+	report.record("system", "system_merged_into_first_user_message", system.Raw)
+	report.MergedSystem = true
+	return result
 }
 
-func sanitizeToolSchemas(body []byte) ([]byte, int) {
+func sanitizeToolSchemas(body []byte, profile *SanitizeProfile, report *SanitizationReport) []byte {
 	tools := gjson.GetBytes(body, "tools")
 	if !tools.IsArray() {
-		return body, 0
+		return body
 	}
 
-	removed := 0
 	result := body
 
+	rewriteOrDelete := func(r []byte, path string, schema gjson.Result) []byte {
+		if profile.RewriteSchemas {
+			return rewriteToolSchema(r, path, schema.Raw, profile, report)
+		}
+		return recursivelyRemoveSchemaFields(r, path, schema, profile, report)
+	}
+
 	for i, tool := range tools.Array() {
 		fn := tool.Get("function")
 		if !fn.Exists() {
 			inputSchema := tool.Get("input_schema")
 			if inputSchema.Exists() {
 				basePath := "tools." + itoa(i) + ".input_schema"
-				var r int
-				result, r = recursivelyRemoveSchemaFields(result, basePath, inputSchema)
-				removed += r
+				result = rewriteOrDelete(result, basePath, inputSchema)
 			}
 			continue
 		}
@@ -549,36 +540,33 @@ func sanitizeToolSchemas(body []byte) ([]byte, int) {
 		params := fn.Get("parameters")
 		if params.Exists() {
 			paramsPath := "tools." + itoa(i) + ".function.parameters"
-			var r int
-			result, r = recursivelyRemoveSchemaFields(result, paramsPath, params)
-			removed += r
+			result = rewriteOrDelete(result, paramsPath, params)
 		}
 
 		inputSchema := fn.Get("input_schema")
 		if inputSchema.Exists() {
 			inputPath := "tools." + itoa(i) + ".function.input_schema"
-			var r int
-			result, r = recursivelyRemoveSchemaFields(result, inputPath, inputSchema)
-			removed += r
+			result = rewriteOrDelete(result, inputPath, inputSchema)
 		}
 
-		if fn.Get("strict").Exists() {
-			result, _ = sjson.DeleteBytes(result, "tools."+itoa(i)+".function.strict")
-			removed++
+		if strict := fn.Get("strict"); strict.Exists() {
+			path := "tools." + itoa(i) + ".function.strict"
+			result, _ = sjson.DeleteBytes(result, path)
+			report.record(path, "unsupported_schema_field", strict.Raw)
 		}
 	}
 
-	return result, removed
+	return result
 }
 
-func recursivelyRemoveSchemaFields(body []byte, basePath string, schema gjson.Result) ([]byte, int) {
+func recursivelyRemoveSchemaFields(body []byte, basePath string, schema gjson.Result, profile *SanitizeProfile, report *SanitizationReport) []byte {
 	result := body
-	removed := 0
 
-	for _, field := range unsupportedSchemaFields {
-		if schema.Get(field).Exists() {
-			result, _ = sjson.DeleteBytes(result, basePath+"."+field)
-			removed++
+	for _, field := range profile.SchemaFieldsToRemove {
+		if v := schema.Get(field); v.Exists() {
+			path := basePath + "." + field
+			result, _ = sjson.DeleteBytes(result, path)
+			report.record(path, "unsupported_schema_field", v.Raw)
 		}
 	}
 
@@ -586,9 +574,7 @@ func recursivelyRemoveSchemaFields(body []byte, basePath string, schema gjson.Re
 	if props.IsObject() {
 		props.ForEach(func(key, value gjson.Result) bool {
 			propPath := basePath + ".properties." + key.String()
-			var r int
-			result, r = recursivelyRemoveSchemaFields(result, propPath, value)
-			removed += r
+			result = recursivelyRemoveSchemaFields(result, propPath, value, profile, report)
 			return true
 		})
 	}
@@ -596,34 +582,31 @@ func recursivelyRemoveSchemaFields(body []byte, basePath string, schema gjson.Re
 	items := schema.Get("items")
 	if items.Exists() && items.IsObject() {
 		itemsPath := basePath + ".items"
-		var r int
-		result, r = recursivelyRemoveSchemaFields(result, itemsPath, items)
-		removed += r
+		result = recursivelyRemoveSchemaFields(result, itemsPath, items, profile, report)
 	}
 
-	return result, removed
+	return result
 }
 
-func sanitizeSystemField(body []byte) ([]byte, int) {
+func sanitizeSystemField(body []byte, report *SanitizationReport) []byte {
 	system := gjson.GetBytes(body, "system")
 	if !system.Exists() {
-		return body, 0
+		return body
 	}
 
-	removed := 0
 	result := body
 
 	if system.IsArray() {
 		for i, item := range system.Array() {
-			if item.Get("cache_control").Exists() {
+			if cc := item.Get("cache_control"); cc.Exists() {
 				path := "system." + itoa(i) + ".cache_control"
 				result, _ = sjson.DeleteBytes(result, path)
-				removed++
+				report.record(path, "cache_control_unsupported", cc.Raw)
 			}
 		}
 	}
 
-	return result, removed
+	return result
 }
 
 func itoa(i int) string {
@@ -0,0 +1,701 @@
+// Package middleware provides HTTP middleware components for the CLI Proxy API server.
+// This file contains the response counterpart to RequestSanitizeMiddleware: upstream
+// backends speak one wire format (OpenAI chat-completion shaped, the format Gemini's
+// OpenAI-compatible endpoint returns), but the client that opened the connection may be
+// speaking Anthropic's Messages API or OpenAI's Responses API. ResponseSanitizeMiddleware
+// buffers (or, for SSE, frame-by-frame re-serializes) the upstream body and inverts the
+// request-side transforms so the client gets a reply shaped like the dialect it sent.
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+// clientDialect identifies the wire format the original client expects its response in.
+type clientDialect string
+
+const (
+	dialectAnthropic clientDialect = "anthropic"
+	dialectResponses clientDialect = "responses"
+	dialectOpenAI    clientDialect = "openai"
+)
+
+// sanitizeContextKey is the gin.Context key RequestSanitizeMiddleware stores a
+// requestSanitizeContext under, for ResponseSanitizeMiddleware to read back.
+const sanitizeContextKey = "middleware.sanitize_context"
+
+// requestSanitizeContext carries what ResponseSanitizeMiddleware needs to invert the
+// request-side transforms, gathered while the request was being sanitized. It is
+// deliberately separate from SanitizationReport: the report is hashed/public (it backs
+// /admin/sanitize/recent and the X-Sanitize-Report header), while this struct holds the
+// actual values needed to reconstruct the response and never leaves the request scope.
+type requestSanitizeContext struct {
+	Profile          *SanitizeProfile
+	Dialect          clientDialect
+	MergedSystemText string
+}
+
+// detectDialect infers the client's expected response dialect from the request path.
+func detectDialect(path string) clientDialect {
+	switch {
+	case strings.Contains(path, "/messages"):
+		return dialectAnthropic
+	case strings.Contains(path, "/responses"):
+		return dialectResponses
+	default:
+		return dialectOpenAI
+	}
+}
+
+// ResponseSanitizeMiddleware wraps the response writer for sanitized routes so the
+// upstream's OpenAI-shaped reply is re-inflated into the dialect the request path implies
+// (see detectDialect). It must be registered after RequestSanitizeMiddleware so
+// requestSanitizeContext is already present on the gin.Context; when it is absent, the
+// profile comes from selector and no merged system text is echoed back.
+func ResponseSanitizeMiddleware(selector ProfileSelector) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		path := c.Request.URL.Path
+		if c.Request.Method != "POST" || !shouldSanitizeRequest(path) {
+			c.Next()
+			return
+		}
+
+		rctx, _ := c.Get(sanitizeContextKey)
+		sctx, _ := rctx.(*requestSanitizeContext)
+		if sctx == nil {
+			profile := selector(path)
+			if profile == nil {
+				c.Next()
+				return
+			}
+			sctx = &requestSanitizeContext{Profile: profile, Dialect: detectDialect(path)}
+		}
+
+		writer := &sanitizeResponseWriter{ResponseWriter: c.Writer, ctx: sctx}
+		c.Writer = writer
+
+		c.Next()
+
+		writer.finalize()
+	}
+}
+
+// sanitizeResponseWriter buffers a non-streaming JSON body so it can be rewritten once
+// the upstream finishes, or re-serializes an SSE stream frame-by-frame as it arrives.
+type sanitizeResponseWriter struct {
+	gin.ResponseWriter
+	ctx *requestSanitizeContext
+
+	streaming   bool
+	wroteHeader bool
+	statusCode  int
+
+	buf      bytes.Buffer // non-streaming: the full upstream body
+	sseCarry []byte       // streaming: bytes received but not yet a complete "\n\n" frame
+	state    streamInversionState
+}
+
+// streamInversionState tracks which Anthropic/Responses lifecycle events have already
+// been emitted for the current SSE stream, since the upstream's OpenAI-style chunks carry
+// no equivalent of their own and the inverted dialects require each exactly once: a single
+// message_start/response.created up front, one content_block_start/response.output_item.added
+// per block index before its first delta, and at the end a content_block_stop per block
+// (Anthropic) or a single response.completed (Responses; no per-item done events). For the
+// Responses dialect, response.completed must carry the full final response object (unlike
+// Anthropic's message_stop, which carries nothing), so deltas are also accumulated here.
+type streamInversionState struct {
+	messageStarted bool
+	startedBlocks  map[int]bool
+
+	responseID     string
+	responseModel  string
+	accumText      strings.Builder
+	accumToolCalls map[int]*accumulatedToolCall
+}
+
+// accumulatedToolCall assembles one tool call's id/name/arguments across however many
+// delta chunks the upstream splits it into, so a dialect's terminal event can report the
+// whole call rather than just the last fragment.
+type accumulatedToolCall struct {
+	id        string
+	name      string
+	arguments strings.Builder
+}
+
+func (s *streamInversionState) blockStarted(index int) bool {
+	return s.startedBlocks[index]
+}
+
+func (s *streamInversionState) markBlockStarted(index int) {
+	if s.startedBlocks == nil {
+		s.startedBlocks = map[int]bool{}
+	}
+	s.startedBlocks[index] = true
+}
+
+// accumulateToolCall records tc against its index, creating the accumulator on first use.
+func (s *streamInversionState) accumulateToolCall(tc upstreamToolCall) {
+	if s.accumToolCalls == nil {
+		s.accumToolCalls = map[int]*accumulatedToolCall{}
+	}
+	acc, ok := s.accumToolCalls[tc.Index]
+	if !ok {
+		acc = &accumulatedToolCall{id: tc.ID, name: tc.Function.Name}
+		s.accumToolCalls[tc.Index] = acc
+	}
+	acc.arguments.WriteString(tc.Function.Arguments)
+}
+
+// responsesCompletedObject builds the final response object response.completed carries,
+// from the deltas accumulated over the stream.
+func (s *streamInversionState) responsesCompletedObject() map[string]interface{} {
+	output := []map[string]interface{}{}
+
+	if s.accumText.Len() > 0 {
+		output = append(output, map[string]interface{}{
+			"type": "message",
+			"role": "assistant",
+			"content": []map[string]interface{}{
+				{"type": "output_text", "text": s.accumText.String()},
+			},
+		})
+	}
+
+	indices := make([]int, 0, len(s.accumToolCalls))
+	for index := range s.accumToolCalls {
+		indices = append(indices, index)
+	}
+	sort.Ints(indices)
+	for _, index := range indices {
+		tc := s.accumToolCalls[index]
+		output = append(output, map[string]interface{}{
+			"type":      "function_call",
+			"call_id":   tc.id,
+			"name":      tc.name,
+			"arguments": tc.arguments.String(),
+		})
+	}
+
+	return map[string]interface{}{
+		"id":     s.responseID,
+		"object": "response",
+		"model":  s.responseModel,
+		"status": "completed",
+		"output": output,
+	}
+}
+
+func (w *sanitizeResponseWriter) WriteHeader(code int) {
+	w.statusCode = code
+	w.wroteHeader = true
+	w.streaming = strings.Contains(w.ResponseWriter.Header().Get("Content-Type"), "text/event-stream")
+
+	if w.streaming {
+		// Streaming bodies are re-serialized frame-by-frame and change size in the
+		// process, so the original Content-Length can't be trusted; let the transport chunk it.
+		w.ResponseWriter.Header().Del("Content-Length")
+		w.ResponseWriter.WriteHeader(code)
+	}
+	// Non-streaming: defer the real WriteHeader until finalize, once the rewritten
+	// body's length is known.
+}
+
+func (w *sanitizeResponseWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(200)
+	}
+
+	if w.streaming {
+		return w.writeStreamingChunk(b)
+	}
+
+	return w.buf.Write(b)
+}
+
+// WriteString is part of gin.ResponseWriter; handlers that call it directly (e.g. via
+// c.String) must still go through the same buffering/streaming path as Write.
+func (w *sanitizeResponseWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+// finalize rewrites and flushes a buffered (non-streaming) response. It is a no-op for
+// streaming responses, which were already written frame-by-frame.
+func (w *sanitizeResponseWriter) finalize() {
+	if w.streaming || !w.wroteHeader {
+		return
+	}
+
+	body := w.buf.Bytes()
+	transformed, err := invertResponseBody(body, w.ctx)
+	if err != nil {
+		log.Debugf("response_sanitize: profile=%s dialect=%s invert failed, wrapping upstream body instead: %v",
+			w.ctx.Profile.Name, w.ctx.Dialect, err)
+	}
+
+	w.ResponseWriter.Header().Set("Content-Length", fmt.Sprintf("%d", len(transformed)))
+	w.ResponseWriter.Header().Del("Transfer-Encoding")
+	w.ResponseWriter.WriteHeader(w.statusCode)
+	_, _ = w.ResponseWriter.Write(transformed)
+}
+
+// writeStreamingChunk buffers b alongside any carried-over partial frame, re-serializes
+// every complete "\n\n"-terminated SSE frame for the client's dialect, and writes each
+// one through immediately so the client still sees a live stream.
+func (w *sanitizeResponseWriter) writeStreamingChunk(b []byte) (int, error) {
+	w.sseCarry = append(w.sseCarry, b...)
+
+	for {
+		idx := bytes.Index(w.sseCarry, []byte("\n\n"))
+		if idx < 0 {
+			break
+		}
+
+		frame := w.sseCarry[:idx]
+		w.sseCarry = w.sseCarry[idx+2:]
+
+		rewritten := invertSSEFrame(frame, w.ctx, &w.state)
+		if len(rewritten) == 0 {
+			continue
+		}
+		if _, err := w.ResponseWriter.Write(rewritten); err != nil {
+			return 0, err
+		}
+	}
+
+	return len(b), nil
+}
+
+// upstreamChatCompletion is the shape this middleware assumes the upstream backend
+// returns: an OpenAI chat-completion response (or, for streaming, a chunk of one).
+type upstreamChatCompletion struct {
+	ID      string `json:"id"`
+	Model   string `json:"model"`
+	Choices []struct {
+		Index        int    `json:"index"`
+		FinishReason string `json:"finish_reason"`
+		Message      *struct {
+			Content   string             `json:"content"`
+			ToolCalls []upstreamToolCall `json:"tool_calls"`
+			Role      string             `json:"role"`
+		} `json:"message"`
+		Delta *struct {
+			Content   string             `json:"content"`
+			ToolCalls []upstreamToolCall `json:"tool_calls"`
+		} `json:"delta"`
+	} `json:"choices"`
+	Usage *struct {
+		PromptTokens     int `json:"prompt_tokens"`
+		CompletionTokens int `json:"completion_tokens"`
+	} `json:"usage"`
+}
+
+type upstreamToolCall struct {
+	Index    int    `json:"index"`
+	ID       string `json:"id"`
+	Type     string `json:"type"`
+	Function struct {
+		Name      string `json:"name"`
+		Arguments string `json:"arguments"`
+	} `json:"function"`
+}
+
+// invertResponseBody converts a complete, non-streaming upstream response into the shape
+// ctx.Dialect expects. OpenAI-dialect clients get the upstream body untouched, since it
+// is already in their wire format. If the upstream body can't be decoded as the expected
+// shape (an error envelope, a future upstream format change, ...), the raw body is still
+// wrapped as a single text block/message in the client's own dialect rather than handed
+// back verbatim in the wrong shape, which a strict dialect-specific client can't parse.
+func invertResponseBody(body []byte, ctx *requestSanitizeContext) ([]byte, error) {
+	if ctx.Dialect == dialectOpenAI || len(body) == 0 {
+		return body, nil
+	}
+
+	var upstream upstreamChatCompletion
+	if err := json.Unmarshal(body, &upstream); err != nil {
+		return wrapUnparsedBody(body, ctx.Dialect), fmt.Errorf("response_sanitize: decode upstream body: %w", err)
+	}
+	if len(upstream.Choices) == 0 || upstream.Choices[0].Message == nil {
+		return wrapUnparsedBody(body, ctx.Dialect), nil
+	}
+	choice := upstream.Choices[0].Message
+
+	var out []byte
+	var err error
+	switch ctx.Dialect {
+	case dialectAnthropic:
+		out, err = json.Marshal(buildAnthropicMessage(upstream, choice.Content, choice.ToolCalls))
+	case dialectResponses:
+		out, err = json.Marshal(buildResponsesObject(upstream, choice.Content, choice.ToolCalls, ctx.MergedSystemText))
+	default:
+		return body, nil
+	}
+	if err != nil {
+		return wrapUnparsedBody(body, ctx.Dialect), fmt.Errorf("response_sanitize: encode inverted body: %w", err)
+	}
+	return out, nil
+}
+
+func buildAnthropicMessage(upstream upstreamChatCompletion, content string, toolCalls []upstreamToolCall) map[string]interface{} {
+	blocks := []map[string]interface{}{}
+	if content != "" {
+		blocks = append(blocks, map[string]interface{}{"type": "text", "text": content})
+	}
+	for _, tc := range toolCalls {
+		blocks = append(blocks, map[string]interface{}{
+			"type":  "tool_use",
+			"id":    tc.ID,
+			"name":  tc.Function.Name,
+			"input": json.RawMessage(orEmptyObject(tc.Function.Arguments)),
+		})
+	}
+
+	stopReason := "end_turn"
+	if len(upstream.Choices) > 0 {
+		switch upstream.Choices[0].FinishReason {
+		case "tool_calls":
+			stopReason = "tool_use"
+		case "length":
+			stopReason = "max_tokens"
+		}
+	}
+
+	msg := map[string]interface{}{
+		"id":          upstream.ID,
+		"type":        "message",
+		"role":        "assistant",
+		"model":       upstream.Model,
+		"content":     blocks,
+		"stop_reason": stopReason,
+	}
+	if upstream.Usage != nil {
+		msg["usage"] = map[string]interface{}{
+			"input_tokens":  upstream.Usage.PromptTokens,
+			"output_tokens": upstream.Usage.CompletionTokens,
+		}
+	}
+	return msg
+}
+
+func buildResponsesObject(upstream upstreamChatCompletion, content string, toolCalls []upstreamToolCall, mergedSystemText string) map[string]interface{} {
+	output := []map[string]interface{}{}
+	if content != "" {
+		output = append(output, map[string]interface{}{
+			"type": "message",
+			"role": "assistant",
+			"content": []map[string]interface{}{
+				{"type": "output_text", "text": content},
+			},
+		})
+	}
+	for _, tc := range toolCalls {
+		output = append(output, map[string]interface{}{
+			"type":      "function_call",
+			"call_id":   tc.ID,
+			"name":      tc.Function.Name,
+			"arguments": tc.Function.Arguments,
+		})
+	}
+
+	obj := map[string]interface{}{
+		"id":     upstream.ID,
+		"object": "response",
+		"model":  upstream.Model,
+		"output": output,
+	}
+	if mergedSystemText != "" {
+		// The request side merged "system" into the first user message (see
+		// mergeSystemToFirstUserMessage); echo it back under the Responses API's own
+		// top-level instructions field so a Responses client still sees it.
+		obj["instructions"] = mergedSystemText
+	}
+	if upstream.Usage != nil {
+		obj["usage"] = map[string]interface{}{
+			"input_tokens":  upstream.Usage.PromptTokens,
+			"output_tokens": upstream.Usage.CompletionTokens,
+		}
+	}
+	return obj
+}
+
+// wrapUnparsedBody wraps a raw upstream body (typically an error envelope this
+// middleware doesn't otherwise understand) as a single text block/message in ctx's
+// dialect, so the client at least gets a well-formed reply in its own wire format
+// instead of an OpenAI-shaped body it can't parse.
+func wrapUnparsedBody(body []byte, dialect clientDialect) []byte {
+	text := string(body)
+
+	var out []byte
+	var err error
+	switch dialect {
+	case dialectAnthropic:
+		out, err = json.Marshal(map[string]interface{}{
+			"type":    "message",
+			"role":    "assistant",
+			"content": []map[string]interface{}{{"type": "text", "text": text}},
+		})
+	case dialectResponses:
+		out, err = json.Marshal(map[string]interface{}{
+			"object": "response",
+			"output": []map[string]interface{}{{
+				"type":    "message",
+				"role":    "assistant",
+				"content": []map[string]interface{}{{"type": "output_text", "text": text}},
+			}},
+		})
+	default:
+		return body
+	}
+	if err != nil {
+		return body
+	}
+	return out
+}
+
+func orEmptyObject(raw string) string {
+	if raw == "" {
+		return "{}"
+	}
+	return raw
+}
+
+// invertSSEFrame rewrites a single "\n\n"-delimited SSE frame (without the trailing
+// blank line) from the upstream's OpenAI-style streaming chunk into ctx.Dialect's own
+// streaming event shape, re-adding the trailing blank line terminator. state tracks which
+// lifecycle events (message_start, content_block_start/stop, ...) have already been sent
+// for this stream, since each is only valid once per connection.
+func invertSSEFrame(frame []byte, ctx *requestSanitizeContext, state *streamInversionState) []byte {
+	dataLine, ok := sseDataPayload(frame)
+	if !ok {
+		return append(append([]byte{}, frame...), '\n', '\n')
+	}
+
+	if dataLine == "[DONE]" {
+		return []byte("data: [DONE]\n\n")
+	}
+
+	if ctx.Dialect == dialectOpenAI {
+		return append(append([]byte{}, frame...), '\n', '\n')
+	}
+
+	var upstream upstreamChatCompletion
+	if err := json.Unmarshal([]byte(dataLine), &upstream); err != nil || len(upstream.Choices) == 0 || upstream.Choices[0].Delta == nil {
+		return append(append([]byte{}, frame...), '\n', '\n')
+	}
+	delta := upstream.Choices[0].Delta
+	finishReason := upstream.Choices[0].FinishReason
+
+	var events []map[string]interface{}
+	switch ctx.Dialect {
+	case dialectAnthropic:
+		events = buildAnthropicStreamEvents(state, upstream, delta, finishReason)
+	case dialectResponses:
+		events = buildResponsesStreamEvents(state, upstream, delta, finishReason)
+	default:
+		return append(append([]byte{}, frame...), '\n', '\n')
+	}
+
+	var out []byte
+	for _, event := range events {
+		encoded, err := json.Marshal(event)
+		if err != nil {
+			continue
+		}
+		eventType, _ := event["type"].(string)
+		out = append(out, []byte("event: "+eventType+"\n")...)
+		out = append(out, []byte("data: ")...)
+		out = append(out, encoded...)
+		out = append(out, '\n', '\n')
+	}
+	return out
+}
+
+// anthropicStopReason maps an upstream OpenAI finish_reason to the Anthropic stop_reason
+// carried on message_delta, matching the mapping buildAnthropicMessage uses for the
+// non-streaming path.
+func anthropicStopReason(finishReason string) string {
+	switch finishReason {
+	case "tool_calls":
+		return "tool_use"
+	case "length":
+		return "max_tokens"
+	default:
+		return "end_turn"
+	}
+}
+
+// buildAnthropicStreamEvents converts one upstream delta chunk into Anthropic Messages
+// streaming events, emitting message_start once up front and, per content block index, a
+// content_block_start (carrying id/name for tool_use blocks) before its first delta. A
+// chunk carrying multiple parallel tool_calls (each tagged with its own Index by the
+// upstream) becomes one content_block_start/content_block_delta pair per call, so the
+// client can demultiplex them into separate content blocks instead of interleaving their
+// argument fragments into a single stream. On finish, every block that was started is
+// closed, followed by message_delta (carrying stop_reason) and message_stop.
+func buildAnthropicStreamEvents(state *streamInversionState, upstream upstreamChatCompletion, delta *struct {
+	Content   string             `json:"content"`
+	ToolCalls []upstreamToolCall `json:"tool_calls"`
+}, finishReason string) []map[string]interface{} {
+	var events []map[string]interface{}
+
+	if !state.messageStarted {
+		state.messageStarted = true
+		events = append(events, map[string]interface{}{
+			"type": "message_start",
+			"message": map[string]interface{}{
+				"id":      upstream.ID,
+				"type":    "message",
+				"role":    "assistant",
+				"model":   upstream.Model,
+				"content": []interface{}{},
+			},
+		})
+	}
+
+	if len(delta.ToolCalls) > 0 {
+		for _, tc := range delta.ToolCalls {
+			if !state.blockStarted(tc.Index) {
+				state.markBlockStarted(tc.Index)
+				events = append(events, map[string]interface{}{
+					"type":  "content_block_start",
+					"index": tc.Index,
+					"content_block": map[string]interface{}{
+						"type":  "tool_use",
+						"id":    tc.ID,
+						"name":  tc.Function.Name,
+						"input": map[string]interface{}{},
+					},
+				})
+			}
+			events = append(events, map[string]interface{}{
+				"type":  "content_block_delta",
+				"index": tc.Index,
+				"delta": map[string]interface{}{
+					"type":         "input_json_delta",
+					"partial_json": tc.Function.Arguments,
+				},
+			})
+		}
+	} else if delta.Content != "" {
+		if !state.blockStarted(0) {
+			state.markBlockStarted(0)
+			events = append(events, map[string]interface{}{
+				"type":          "content_block_start",
+				"index":         0,
+				"content_block": map[string]interface{}{"type": "text", "text": ""},
+			})
+		}
+		events = append(events, map[string]interface{}{
+			"type":  "content_block_delta",
+			"index": 0,
+			"delta": map[string]interface{}{
+				"type": "text_delta",
+				"text": delta.Content,
+			},
+		})
+	}
+
+	if finishReason != "" {
+		for index := range state.startedBlocks {
+			events = append(events, map[string]interface{}{"type": "content_block_stop", "index": index})
+		}
+		events = append(events, map[string]interface{}{
+			"type":  "message_delta",
+			"delta": map[string]interface{}{"stop_reason": anthropicStopReason(finishReason)},
+		})
+		events = append(events, map[string]interface{}{"type": "message_stop"})
+	}
+
+	return events
+}
+
+// buildResponsesStreamEvents is the Responses-API analogue of buildAnthropicStreamEvents:
+// it emits response.created once up front (mirroring message_start), response.output_item.added
+// once per output index before its first delta (carrying call_id/name for function calls,
+// mirroring the id/name the non-streaming path already includes via buildResponsesObject),
+// and on finish a response.completed carrying the full accumulated response object, since
+// unlike Anthropic's message_stop a Responses client expects the terminal event to carry it.
+func buildResponsesStreamEvents(state *streamInversionState, upstream upstreamChatCompletion, delta *struct {
+	Content   string             `json:"content"`
+	ToolCalls []upstreamToolCall `json:"tool_calls"`
+}, finishReason string) []map[string]interface{} {
+	var events []map[string]interface{}
+
+	if !state.messageStarted {
+		state.messageStarted = true
+		state.responseID = upstream.ID
+		state.responseModel = upstream.Model
+		events = append(events, map[string]interface{}{
+			"type": "response.created",
+			"response": map[string]interface{}{
+				"id":     upstream.ID,
+				"object": "response",
+				"model":  upstream.Model,
+				"status": "in_progress",
+			},
+		})
+	}
+
+	if len(delta.ToolCalls) > 0 {
+		for _, tc := range delta.ToolCalls {
+			if !state.blockStarted(tc.Index) {
+				state.markBlockStarted(tc.Index)
+				events = append(events, map[string]interface{}{
+					"type":         "response.output_item.added",
+					"output_index": tc.Index,
+					"item": map[string]interface{}{
+						"type":    "function_call",
+						"call_id": tc.ID,
+						"name":    tc.Function.Name,
+					},
+				})
+			}
+			state.accumulateToolCall(tc)
+			events = append(events, map[string]interface{}{
+				"type":         "response.function_call_arguments.delta",
+				"output_index": tc.Index,
+				"delta":        tc.Function.Arguments,
+			})
+		}
+	} else if delta.Content != "" {
+		if !state.blockStarted(0) {
+			state.markBlockStarted(0)
+			events = append(events, map[string]interface{}{
+				"type":         "response.output_item.added",
+				"output_index": 0,
+				"item":         map[string]interface{}{"type": "message", "role": "assistant"},
+			})
+		}
+		state.accumText.WriteString(delta.Content)
+		events = append(events, map[string]interface{}{
+			"type":  "response.output_text.delta",
+			"delta": delta.Content,
+		})
+	}
+
+	if finishReason != "" {
+		events = append(events, map[string]interface{}{
+			"type":     "response.completed",
+			"response": state.responsesCompletedObject(),
+		})
+	}
+
+	return events
+}
+
+// sseDataPayload extracts the payload of a frame's "data: " line, if any. SSE frames may
+// carry other fields (event:, id:, retry:, ": comment"); only the data line matters here.
+func sseDataPayload(frame []byte) (string, bool) {
+	for _, line := range strings.Split(string(frame), "\n") {
+		if rest, found := strings.CutPrefix(line, "data:"); found {
+			return strings.TrimSpace(rest), true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,218 @@
+// Package middleware provides HTTP middleware components for the CLI Proxy API server.
+// This file defines sanitization profiles: declarative, per-backend descriptions of which
+// fields and transforms RequestSanitizeMiddleware should apply, so that new upstreams can
+// be supported by dropping in a config file instead of forking the middleware.
+package middleware
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// SanitizeProfile declares the set of transforms RequestSanitizeMiddleware applies to a
+// request body before it is forwarded to a given upstream backend. A profile is usually
+// loaded from a YAML or JSON file at startup and selected per route or per upstream.
+type SanitizeProfile struct {
+	// Name identifies the profile, e.g. "gemini", "claude-passthrough", "openai-compatible".
+	Name string `yaml:"name" json:"name"`
+
+	// RootFieldsToRemove lists top-level request fields the backend does not understand.
+	RootFieldsToRemove []string `yaml:"root_fields_to_remove" json:"root_fields_to_remove"`
+
+	// SchemaFieldsToRemove lists JSON Schema keywords to strip from tool/function schemas
+	// via blind deletion. Ignored when RewriteSchemas is true, since rewriteToolSchema
+	// handles unsupported keywords itself (inlining, collapsing, or folding them into
+	// the description instead of deleting them outright).
+	SchemaFieldsToRemove []string `yaml:"schema_fields_to_remove" json:"schema_fields_to_remove"`
+
+	// RewriteSchemas switches tool schema sanitization from blind keyword deletion to
+	// the Gemini-compatible rewriter: it inlines $ref/$defs, collapses oneOf/anyOf/allOf,
+	// and folds format/pattern/length constraints into the description instead of
+	// destroying the tool contract.
+	RewriteSchemas bool `yaml:"rewrite_schemas" json:"rewrite_schemas"`
+
+	// UnsupportedToolChoiceValues lists tool_choice string/type values the backend rejects;
+	// matching values are normalized to "auto". Independently of this list, every profile
+	// normalizes an object tool_choice of type "auto", "any" or empty to "auto" and drops
+	// one of type "function" or "tool"; this list does not change those rules.
+	UnsupportedToolChoiceValues []string `yaml:"unsupported_tool_choice_values" json:"unsupported_tool_choice_values"`
+
+	// RemoveCacheControl strips Anthropic-style cache_control blocks from messages/system.
+	RemoveCacheControl bool `yaml:"remove_cache_control" json:"remove_cache_control"`
+
+	// ConvertClaudeToolUse rewrites Claude tool_use/tool_result blocks into OpenAI
+	// tool_calls/tool role messages.
+	ConvertClaudeToolUse bool `yaml:"convert_claude_tool_use" json:"convert_claude_tool_use"`
+
+	// FlattenMessageContent joins multi-part text content into a single string field.
+	FlattenMessageContent bool `yaml:"flatten_message_content" json:"flatten_message_content"`
+
+	// FixEmptyAssistantMessages pads or drops assistant messages with empty content.
+	FixEmptyAssistantMessages bool `yaml:"fix_empty_assistant_messages" json:"fix_empty_assistant_messages"`
+
+	// MergeSystemOnModelMatch merges the top-level "system" field into the first user
+	// message when the request model name contains ModelMatch (case-insensitive).
+	MergeSystemOnModelMatch bool   `yaml:"merge_system_on_model_match" json:"merge_system_on_model_match"`
+	ModelMatch              string `yaml:"model_match" json:"model_match"`
+
+	// StreamingThresholdBytes overrides defaultStreamingThresholdBytes: request bodies at
+	// or above this size are sanitized with the single-pass streaming rewriter instead of
+	// the gjson/sjson pipeline. Zero means "use the default".
+	StreamingThresholdBytes int `yaml:"streaming_threshold_bytes" json:"streaming_threshold_bytes"`
+
+	toolChoiceSet map[string]bool
+}
+
+// compiled returns a copy of the profile with derived lookup structures populated.
+func (p *SanitizeProfile) compiled() *SanitizeProfile {
+	out := *p
+	out.toolChoiceSet = make(map[string]bool, len(p.UnsupportedToolChoiceValues))
+	for _, v := range p.UnsupportedToolChoiceValues {
+		out.toolChoiceSet[v] = true
+	}
+	return &out
+}
+
+func (p *SanitizeProfile) isUnsupportedToolChoice(value string) bool {
+	if p.toolChoiceSet == nil {
+		return false
+	}
+	return p.toolChoiceSet[value]
+}
+
+// GeminiProfile is the built-in default profile aimed at the Gemini API. It strips the
+// Gemini-unsupported root fields and tool_choice values, applies every message transform,
+// and runs tool schemas through the schema rewriter rather than deleting keywords.
+func GeminiProfile() *SanitizeProfile {
+	return (&SanitizeProfile{
+		Name: "gemini",
+		RootFieldsToRemove: []string{
+			"cache_control",
+			"citations",
+			"container",
+			"metadata",
+			"service_tier",
+			"logprobs",
+			"top_logprobs",
+			"logit_bias",
+			"parallel_tool_calls",
+		},
+		RewriteSchemas:              true,
+		UnsupportedToolChoiceValues: []string{"validated", "required"},
+		RemoveCacheControl:          true,
+		ConvertClaudeToolUse:        true,
+		FlattenMessageContent:       true,
+		FixEmptyAssistantMessages:   true,
+		MergeSystemOnModelMatch:     true,
+		ModelMatch:                  "thinking",
+	}).compiled()
+}
+
+// ClaudePassthroughProfile leaves tool schemas and message content untouched, since the
+// upstream already speaks Claude's dialect. It normalizes the string tool_choice
+// "validated" to "auto"; the object tool_choice rules every profile applies (see
+// UnsupportedToolChoiceValues) apply here too.
+func ClaudePassthroughProfile() *SanitizeProfile {
+	return (&SanitizeProfile{
+		Name:                        "claude-passthrough",
+		UnsupportedToolChoiceValues: []string{"validated"},
+	}).compiled()
+}
+
+// OpenAICompatibleProfile targets local OpenAI-compatible servers (e.g. vLLM, llama.cpp)
+// that accept standard JSON Schema but not Anthropic-style cache_control blocks or
+// Claude's tool_use/tool_result content shape.
+func OpenAICompatibleProfile() *SanitizeProfile {
+	return (&SanitizeProfile{
+		Name:                      "openai-compatible",
+		RootFieldsToRemove:        []string{"cache_control", "citations", "container"},
+		RemoveCacheControl:        true,
+		ConvertClaudeToolUse:      true,
+		FixEmptyAssistantMessages: true,
+	}).compiled()
+}
+
+// builtinProfiles maps profile names to their constructors, used by BuiltinSanitizeProfile
+// to look up a built-in profile by name.
+var builtinProfiles = map[string]func() *SanitizeProfile{
+	"gemini":             GeminiProfile,
+	"claude-passthrough": ClaudePassthroughProfile,
+	"openai-compatible":  OpenAICompatibleProfile,
+}
+
+// BuiltinSanitizeProfile returns the built-in profile registered under name, if any.
+func BuiltinSanitizeProfile(name string) (*SanitizeProfile, bool) {
+	ctor, ok := builtinProfiles[name]
+	if !ok {
+		return nil, false
+	}
+	return ctor(), true
+}
+
+// LoadSanitizeProfile reads a SanitizeProfile from a YAML or JSON file, selecting the
+// decoder based on the file extension (.json vs .yaml/.yml).
+func LoadSanitizeProfile(path string) (*SanitizeProfile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("sanitize profile: read %s: %w", path, err)
+	}
+
+	var profile SanitizeProfile
+	if strings.HasSuffix(strings.ToLower(path), ".json") {
+		if err := json.Unmarshal(data, &profile); err != nil {
+			return nil, fmt.Errorf("sanitize profile: parse %s as json: %w", path, err)
+		}
+	} else {
+		if err := yaml.Unmarshal(data, &profile); err != nil {
+			return nil, fmt.Errorf("sanitize profile: parse %s as yaml: %w", path, err)
+		}
+	}
+
+	if profile.Name == "" {
+		return nil, fmt.Errorf("sanitize profile: %s is missing a name", path)
+	}
+
+	return profile.compiled(), nil
+}
+
+// ProfileSelector resolves the SanitizeProfile to apply to a given request path. It is
+// used by RequestSanitizeMiddleware to pick a profile per route or per upstream backend.
+type ProfileSelector func(path string) *SanitizeProfile
+
+// StaticProfileSelector always returns the same profile, regardless of request path.
+func StaticProfileSelector(profile *SanitizeProfile) ProfileSelector {
+	return func(string) *SanitizeProfile {
+		return profile
+	}
+}
+
+// PathProfileSelector picks a profile based on which registered path prefix the request
+// path contains, falling back to def when nothing matches. When more than one prefix
+// matches, the longest one wins (ties go to the lexically smaller prefix), so selection is
+// stable regardless of map iteration order.
+func PathProfileSelector(byPathPrefix map[string]*SanitizeProfile, def *SanitizeProfile) ProfileSelector {
+	prefixes := make([]string, 0, len(byPathPrefix))
+	for prefix := range byPathPrefix {
+		prefixes = append(prefixes, prefix)
+	}
+	sort.Slice(prefixes, func(i, j int) bool {
+		if len(prefixes[i]) != len(prefixes[j]) {
+			return len(prefixes[i]) > len(prefixes[j])
+		}
+		return prefixes[i] < prefixes[j]
+	})
+
+	return func(path string) *SanitizeProfile {
+		for _, prefix := range prefixes {
+			if strings.Contains(path, prefix) {
+				return byPathPrefix[prefix]
+			}
+		}
+		return def
+	}
+}
@@ -0,0 +1,156 @@
+// Package middleware provides HTTP middleware components for the CLI Proxy API server.
+// This file turns sanitization bookkeeping into structured, inspectable diagnostics: a
+// SanitizationReport records exactly which fields were touched and why, a bounded ring
+// buffer keeps the most recent reports in memory for /admin/sanitize/recent, and clients
+// that send X-Sanitize-Debug: 1 get a compact summary echoed back in X-Sanitize-Report.
+package middleware
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// sanitizeDebugHeader is the request header a client sends to opt into receiving a
+// summary of what was sanitized back on the response.
+const sanitizeDebugHeader = "X-Sanitize-Debug"
+
+// sanitizeReportHeader carries the compact JSON summary back to a debugging client.
+const sanitizeReportHeader = "X-Sanitize-Report"
+
+// recentSanitizeReportsCap bounds the in-memory ring buffer served by
+// /admin/sanitize/recent so a busy proxy can't grow it without limit.
+const recentSanitizeReportsCap = 200
+
+// SanitizeEvent records a single field that the sanitizer touched.
+type SanitizeEvent struct {
+	FieldPath         string `json:"field_path"`
+	Reason            string `json:"reason"`
+	OriginalValueHash string `json:"original_value_hash,omitempty"`
+}
+
+// SanitizationReport is the structured record of everything RequestSanitizeMiddleware
+// did to a single request body.
+type SanitizationReport struct {
+	Path              string          `json:"path"`
+	Profile           string          `json:"profile"`
+	Timestamp         time.Time       `json:"timestamp"`
+	Events            []SanitizeEvent `json:"events"`
+	FlattenedMessages int             `json:"flattened_messages,omitempty"`
+	MergedSystem      bool            `json:"merged_system,omitempty"`
+
+	// mergedSystemText holds the original "system" text that was merged into the first
+	// user message, so ResponseSanitizeMiddleware can echo it back as a top-level field
+	// for dialects that expect one (see requestSanitizeContext). Unexported: it never
+	// reaches the ring buffer's JSON output or the X-Sanitize-Report header.
+	mergedSystemText string
+}
+
+// record appends a sanitize event for fieldPath, hashing originalValue so operators can
+// correlate repeated reports without the report itself leaking request content.
+func (r *SanitizationReport) record(fieldPath, reason, originalValue string) {
+	r.Events = append(r.Events, SanitizeEvent{
+		FieldPath:         fieldPath,
+		Reason:            reason,
+		OriginalValueHash: hashSanitizedValue(originalValue),
+	})
+}
+
+func hashSanitizedValue(value string) string {
+	if value == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(value))
+	return hex.EncodeToString(sum[:])[:16]
+}
+
+// summary is the compact form echoed back via the X-Sanitize-Report response header:
+// just the touched field paths, not the reasons or hashes, to keep the header small.
+func (r *SanitizationReport) summary() map[string]interface{} {
+	paths := make([]string, len(r.Events))
+	for i, e := range r.Events {
+		paths[i] = e.FieldPath
+	}
+	return map[string]interface{}{
+		"profile": r.Profile,
+		"removed": paths,
+	}
+}
+
+// sanitizeReportRing is a fixed-capacity, overwrite-oldest ring buffer of recent
+// SanitizationReports, safe for concurrent use across request goroutines.
+type sanitizeReportRing struct {
+	mu      sync.Mutex
+	entries []SanitizationReport
+	cap     int
+	next    int
+	full    bool
+}
+
+func newSanitizeReportRing(capacity int) *sanitizeReportRing {
+	return &sanitizeReportRing{
+		entries: make([]SanitizationReport, capacity),
+		cap:     capacity,
+	}
+}
+
+func (r *sanitizeReportRing) push(report SanitizationReport) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.entries[r.next] = report
+	r.next = (r.next + 1) % r.cap
+	if r.next == 0 {
+		r.full = true
+	}
+}
+
+// snapshot returns the buffered reports, most recent first.
+func (r *sanitizeReportRing) snapshot() []SanitizationReport {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	count := r.next
+	if r.full {
+		count = r.cap
+	}
+
+	out := make([]SanitizationReport, 0, count)
+	for i := 0; i < count; i++ {
+		idx := (r.next - 1 - i + r.cap) % r.cap
+		out = append(out, r.entries[idx])
+	}
+	return out
+}
+
+// recentSanitizeReports is the process-wide ring buffer backing /admin/sanitize/recent.
+var recentSanitizeReports = newSanitizeReportRing(recentSanitizeReportsCap)
+
+// AdminSanitizeRecentHandler serves the most recent SanitizationReports as JSON, for
+// operators debugging what a client's payload lost on its way to the upstream backend.
+func AdminSanitizeRecentHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.JSON(200, gin.H{"reports": recentSanitizeReports.snapshot()})
+	}
+}
+
+// writeSanitizeDebugHeader echoes a compact summary of report on the response when the
+// client opted in via X-Sanitize-Debug: 1.
+func writeSanitizeDebugHeader(c *gin.Context, report *SanitizationReport) {
+	if c.GetHeader(sanitizeDebugHeader) != "1" {
+		return
+	}
+	if len(report.Events) == 0 {
+		return
+	}
+
+	encoded, err := json.Marshal(report.summary())
+	if err != nil {
+		return
+	}
+	c.Header(sanitizeReportHeader, string(encoded))
+}
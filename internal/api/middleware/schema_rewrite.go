@@ -0,0 +1,348 @@
+// Package middleware provides HTTP middleware components for the CLI Proxy API server.
+// This file replaces blind deletion of unsupported JSON Schema keywords with a real
+// rewriter: it inlines $ref/$defs, collapses oneOf/anyOf into a Gemini-compatible shape,
+// and folds constraints Gemini can't express (format, pattern, length bounds) into the
+// property's description instead of silently dropping them from the tool contract.
+package middleware
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/tidwall/sjson"
+)
+
+// maxRefInlineDepth bounds how many nested $ref expansions schemaRewriter.rewrite will follow
+// before it gives up and deletes the unresolved $ref rather than risk runaway recursion
+// on a pathological (or maliciously deep) schema.
+const maxRefInlineDepth = 8
+
+// schemaUnrepresentableFields lists JSON Schema keywords Gemini's function-calling schema
+// has no equivalent for and that carry no information worth preserving as a description
+// hint, so they're dropped outright once everything else has been rewritten.
+var schemaUnrepresentableFields = []string{
+	"$schema",
+	"$id",
+	"additionalProperties",
+	"exclusiveMinimum",
+	"exclusiveMaximum",
+	"default",
+	"examples",
+	"not",
+	"if",
+	"then",
+	"else",
+	"dependentSchemas",
+	"dependentRequired",
+	"propertyNames",
+	"unevaluatedProperties",
+	"unevaluatedItems",
+	"contentMediaType",
+	"contentEncoding",
+}
+
+// rewriteToolSchema rewrites the tool schema at basePath in body into a Gemini-compatible
+// shape, inlining $ref/$defs, collapsing oneOf/anyOf/allOf, and converting unsupported
+// keywords into description hints. It returns the rewritten body; reported events are
+// appended to report.
+func rewriteToolSchema(body []byte, basePath string, schemaRaw string, profile *SanitizeProfile, report *SanitizationReport) []byte {
+	decoder := json.NewDecoder(strings.NewReader(schemaRaw))
+	decoder.UseNumber()
+
+	var node interface{}
+	if err := decoder.Decode(&node); err != nil {
+		return body
+	}
+
+	defs := collectSchemaDefs(node)
+
+	rw := &schemaRewriter{defs: defs, profile: profile, report: report}
+	rewritten := rw.rewrite(node, basePath, map[string]bool{}, 0)
+
+	encoded, err := json.Marshal(rewritten)
+	if err != nil {
+		return body
+	}
+
+	newBody, err := sjson.SetRawBytes(body, basePath, encoded)
+	if err != nil {
+		return body
+	}
+	return newBody
+}
+
+func collectSchemaDefs(node interface{}) map[string]interface{} {
+	defs := map[string]interface{}{}
+	obj, ok := node.(map[string]interface{})
+	if !ok {
+		return defs
+	}
+
+	for _, key := range []string{"$defs", "definitions"} {
+		container, ok := obj[key].(map[string]interface{})
+		if !ok {
+			continue
+		}
+		for name, def := range container {
+			defs[key+"/"+name] = def
+		}
+	}
+	return defs
+}
+
+type schemaRewriter struct {
+	defs    map[string]interface{}
+	profile *SanitizeProfile
+	report  *SanitizationReport
+}
+
+// rewrite transforms schema in place (functionally, returning the new value). path is
+// the dotted field path used for event reporting; visited guards against cyclic $ref
+// chains, keyed by the "$defs/Name" pointer string; depth counts $ref inline expansions.
+func (rw *schemaRewriter) rewrite(schema interface{}, path string, visited map[string]bool, depth int) interface{} {
+	obj, ok := schema.(map[string]interface{})
+	if !ok {
+		return schema
+	}
+
+	if ref, ok := obj["$ref"].(string); ok {
+		return rw.inlineRef(obj, ref, path, visited, depth)
+	}
+
+	out := make(map[string]interface{}, len(obj))
+	for k, v := range obj {
+		if k == "$defs" || k == "definitions" {
+			rw.report.record(path+"."+k, "defs_inlined_and_removed", "")
+			continue
+		}
+		out[k] = v
+	}
+
+	rw.collapseCompositionKeywords(out, path)
+	rw.applyDescriptionHints(out, path)
+	rw.dropUnrepresentableFields(out, path)
+
+	if props, ok := out["properties"].(map[string]interface{}); ok {
+		newProps := make(map[string]interface{}, len(props))
+		for name, propSchema := range props {
+			newProps[name] = rw.rewrite(propSchema, path+".properties."+name, visited, depth)
+		}
+		out["properties"] = newProps
+	}
+
+	switch items := out["items"].(type) {
+	case map[string]interface{}:
+		out["items"] = rw.rewrite(items, path+".items", visited, depth)
+	case []interface{}:
+		newItems := make([]interface{}, len(items))
+		for i, item := range items {
+			newItems[i] = rw.rewrite(item, fmt.Sprintf("%s.items.%d", path, i), visited, depth)
+		}
+		out["items"] = newItems
+	}
+
+	return out
+}
+
+// inlineRef resolves a local $ref (#/$defs/Name or #/definitions/Name) by substituting
+// the referenced definition's (rewritten) node in place of the $ref. Cyclic references
+// and refs past maxRefInlineDepth fall back to deleting the $ref, matching the old
+// blind-deletion behavior for the cases a rewrite genuinely can't handle.
+func (rw *schemaRewriter) inlineRef(obj map[string]interface{}, ref, path string, visited map[string]bool, depth int) interface{} {
+	pointer := strings.TrimPrefix(ref, "#/")
+
+	target, ok := rw.defs[pointer]
+	if !ok || visited[pointer] || depth >= maxRefInlineDepth {
+		reason := "ref_unresolved_dropped"
+		if visited[pointer] {
+			reason = "ref_cycle_detected_dropped"
+		} else if depth >= maxRefInlineDepth {
+			reason = "ref_depth_cap_exceeded_dropped"
+		}
+		rw.report.record(path+".$ref", reason, ref)
+
+		out := make(map[string]interface{}, len(obj)-1)
+		for k, v := range obj {
+			if k == "$ref" {
+				continue
+			}
+			out[k] = v
+		}
+		if _, hasType := out["type"]; !hasType {
+			out["type"] = "object"
+		}
+		return out
+	}
+
+	visitedNext := make(map[string]bool, len(visited)+1)
+	for k := range visited {
+		visitedNext[k] = true
+	}
+	visitedNext[pointer] = true
+
+	rw.report.record(path+".$ref", "ref_inlined", ref)
+	resolved := rw.rewrite(target, path, visitedNext, depth+1)
+
+	siblings, ok := resolved.(map[string]interface{})
+	if !ok {
+		return resolved
+	}
+	for k, v := range obj {
+		if k == "$ref" {
+			continue
+		}
+		siblings[k] = v
+	}
+	return siblings
+}
+
+// collapseCompositionKeywords handles allOf/oneOf/anyOf, none of which Gemini's function
+// schema supports directly. allOf is a merge (Gemini needs one flat object anyway); for
+// oneOf/anyOf, a set of pure primitive-type branches collapses into a "type" array, and a
+// mixed set keeps the first object-shaped branch as the effective schema, annotating the
+// branches it couldn't keep under x-original-variants so the contract isn't silently lost.
+func (rw *schemaRewriter) collapseCompositionKeywords(out map[string]interface{}, path string) {
+	if allOf, ok := out["allOf"].([]interface{}); ok {
+		for _, branch := range allOf {
+			branchObj, ok := branch.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			mergeSchemaInto(out, branchObj)
+		}
+		delete(out, "allOf")
+		rw.report.record(path+".allOf", "allOf_merged", "")
+	}
+
+	for _, keyword := range []string{"oneOf", "anyOf"} {
+		branches, ok := out[keyword].([]interface{})
+		if !ok {
+			continue
+		}
+
+		if types, isPrimitive := primitiveBranchTypes(branches); isPrimitive {
+			out["type"] = types
+			delete(out, keyword)
+			rw.report.record(path+"."+keyword, keyword+"_collapsed_to_type_array", "")
+			continue
+		}
+
+		base, rest := firstObjectBranch(branches)
+		if base != nil {
+			mergeSchemaInto(out, base)
+		}
+		if len(rest) > 0 {
+			out["x-original-variants"] = rest
+		}
+		delete(out, keyword)
+		rw.report.record(path+"."+keyword, keyword+"_collapsed_to_first_branch", "")
+	}
+}
+
+// mergeSchemaInto shallow-merges every top-level key of src into dst without overwriting
+// a key dst already set explicitly.
+func mergeSchemaInto(dst, src map[string]interface{}) {
+	for k, v := range src {
+		if _, exists := dst[k]; exists {
+			continue
+		}
+		dst[k] = v
+	}
+}
+
+// primitiveBranchTypes reports whether every branch is a bare {"type": "..."} schema,
+// returning the collected type values if so.
+func primitiveBranchTypes(branches []interface{}) ([]string, bool) {
+	types := make([]string, 0, len(branches))
+	for _, branch := range branches {
+		obj, ok := branch.(map[string]interface{})
+		if !ok || len(obj) != 1 {
+			return nil, false
+		}
+		t, ok := obj["type"].(string)
+		if !ok {
+			return nil, false
+		}
+		types = append(types, t)
+	}
+	return types, true
+}
+
+// firstObjectBranch returns the first branch shaped like an object schema (has
+// "properties" or "type": "object") plus the remaining branches to annotate.
+func firstObjectBranch(branches []interface{}) (map[string]interface{}, []interface{}) {
+	for i, branch := range branches {
+		obj, ok := branch.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if _, hasProps := obj["properties"]; hasProps || obj["type"] == "object" {
+			rest := make([]interface{}, 0, len(branches)-1)
+			rest = append(rest, branches[:i]...)
+			rest = append(rest, branches[i+1:]...)
+			return obj, rest
+		}
+	}
+	return nil, branches
+}
+
+// applyDescriptionHints folds keywords Gemini's schema can't enforce (format, pattern,
+// length bounds) into the node's description so the model still sees the constraint.
+func (rw *schemaRewriter) applyDescriptionHints(out map[string]interface{}, path string) {
+	var hints []string
+
+	if format, ok := out["format"].(string); ok {
+		hints = append(hints, fmt.Sprintf("format: %s", format))
+		rw.report.record(path+".format", "format_to_description", format)
+		delete(out, "format")
+	}
+	if pattern, ok := out["pattern"].(string); ok {
+		hints = append(hints, fmt.Sprintf("must match pattern: %s", pattern))
+		rw.report.record(path+".pattern", "pattern_to_description", pattern)
+		delete(out, "pattern")
+	}
+	if min, ok := out["minItems"]; ok {
+		hints = append(hints, fmt.Sprintf("minimum %v items", min))
+		rw.report.record(path+".minItems", "array_length_to_description", fmt.Sprintf("%v", min))
+		delete(out, "minItems")
+	}
+	if max, ok := out["maxItems"]; ok {
+		hints = append(hints, fmt.Sprintf("maximum %v items", max))
+		rw.report.record(path+".maxItems", "array_length_to_description", fmt.Sprintf("%v", max))
+		delete(out, "maxItems")
+	}
+	if min, ok := out["minLength"]; ok {
+		hints = append(hints, fmt.Sprintf("minimum length %v", min))
+		rw.report.record(path+".minLength", "string_length_to_description", fmt.Sprintf("%v", min))
+		delete(out, "minLength")
+	}
+	if max, ok := out["maxLength"]; ok {
+		hints = append(hints, fmt.Sprintf("maximum length %v", max))
+		rw.report.record(path+".maxLength", "string_length_to_description", fmt.Sprintf("%v", max))
+		delete(out, "maxLength")
+	}
+
+	if len(hints) == 0 {
+		return
+	}
+
+	description, _ := out["description"].(string)
+	if description != "" {
+		description += " (" + strings.Join(hints, "; ") + ")"
+	} else {
+		description = strings.Join(hints, "; ")
+	}
+	out["description"] = description
+}
+
+// dropUnrepresentableFields deletes keywords that have no Gemini equivalent and carry no
+// information worth preserving as a description hint.
+func (rw *schemaRewriter) dropUnrepresentableFields(out map[string]interface{}, path string) {
+	for _, field := range schemaUnrepresentableFields {
+		if _, ok := out[field]; ok {
+			delete(out, field)
+			rw.report.record(path+"."+field, "unrepresentable_schema_field_dropped", "")
+		}
+	}
+}
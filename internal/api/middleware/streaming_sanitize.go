@@ -0,0 +1,453 @@
+// Package middleware provides HTTP middleware components for the CLI Proxy API server.
+// This file adds a single-pass sanitizer for large request bodies. The default pipeline
+// in request_sanitize.go runs many sequential gjson/sjson passes, each of which reparses
+// and reallocates the whole buffer — fine for typical chat payloads, but wasteful once
+// messages carry base64 images or long tool_result blobs. At or above the profile's
+// streaming threshold (StreamingThresholdBytes, else defaultStreamingThresholdBytes),
+// RequestSanitizeMiddleware instead decodes the body once with encoding/json,
+// applies every transform in one tree walk, and re-encodes it through a pooled buffer.
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultStreamingThresholdBytes is the body size at or above which sanitization switches from
+// the gjson/sjson pipeline to the single-pass streaming rewriter, unless a profile
+// overrides it via StreamingThresholdBytes.
+const defaultStreamingThresholdBytes = 256 * 1024
+
+var streamingBufferPool = sync.Pool{
+	New: func() interface{} { return new(bytes.Buffer) },
+}
+
+// streamingThreshold returns the body size, in bytes, at or above which profile switches to
+// the streaming rewriter.
+func streamingThreshold(profile *SanitizeProfile) int {
+	if profile.StreamingThresholdBytes > 0 {
+		return profile.StreamingThresholdBytes
+	}
+	return defaultStreamingThresholdBytes
+}
+
+// sanitizeRequestBodyStreaming applies every transform profile declares in a single
+// decode/walk/encode pass, instead of the repeated gjson.Get/sjson.Set passes
+// sanitizeRequestBody uses. It falls back (ok=false) on anything encoding/json can't
+// decode into a JSON object, leaving the caller to use the gjson/sjson pipeline instead.
+// Tool schemas always go through the schema rewriter here; SchemaFieldsToRemove is not
+// applied, which is why sanitizeRequestBodyDispatch keeps such profiles on the pipeline.
+func sanitizeRequestBodyStreaming(body []byte, profile *SanitizeProfile) (result []byte, report SanitizationReport, ok bool) {
+	report = SanitizationReport{Timestamp: time.Now()}
+
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+
+	var doc map[string]interface{}
+	if err := decoder.Decode(&doc); err != nil {
+		return nil, report, false
+	}
+
+	for _, field := range profile.RootFieldsToRemove {
+		if v, exists := doc[field]; exists {
+			delete(doc, field)
+			report.record(field, "unsupported_root_field", fmt.Sprint(v))
+		}
+	}
+
+	streamingSanitizeToolChoice(doc, profile, &report)
+
+	if profile.RewriteSchemas || len(profile.SchemaFieldsToRemove) > 0 {
+		streamingSanitizeTools(doc, profile, &report)
+	}
+
+	if messages, isArray := doc["messages"].([]interface{}); isArray {
+		doc["messages"] = streamingSanitizeMessages(messages, profile, &report)
+	}
+
+	if profile.MergeSystemOnModelMatch {
+		model, _ := doc["model"].(string)
+		if profile.ModelMatch == "" || strings.Contains(strings.ToLower(model), strings.ToLower(profile.ModelMatch)) {
+			streamingMergeSystem(doc, &report)
+		}
+	}
+
+	if profile.RemoveCacheControl {
+		streamingSanitizeSystemField(doc, &report)
+	}
+
+	buf := streamingBufferPool.Get().(*bytes.Buffer)
+	buf.Reset()
+	defer streamingBufferPool.Put(buf)
+
+	encoder := json.NewEncoder(buf)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(doc); err != nil {
+		return nil, report, false
+	}
+
+	// json.Encoder.Encode appends a trailing newline; trim it to match sjson's output.
+	out := bytes.TrimRight(buf.Bytes(), "\n")
+	return append([]byte(nil), out...), report, true
+}
+
+func streamingSanitizeToolChoice(doc map[string]interface{}, profile *SanitizeProfile, report *SanitizationReport) {
+	toolChoice, exists := doc["tool_choice"]
+	if !exists {
+		return
+	}
+
+	switch v := toolChoice.(type) {
+	case string:
+		if profile.isUnsupportedToolChoice(v) {
+			doc["tool_choice"] = "auto"
+			report.record("tool_choice", "unsupported_tool_choice_value", v)
+		}
+	case map[string]interface{}:
+		tcType, _ := v["type"].(string)
+		if profile.isUnsupportedToolChoice(tcType) || tcType == "auto" || tcType == "" || tcType == "any" {
+			doc["tool_choice"] = "auto"
+			report.record("tool_choice", "normalized_tool_choice", fmt.Sprint(v))
+		} else if tcType == "function" || tcType == "tool" {
+			delete(doc, "tool_choice")
+			report.record("tool_choice", "unsupported_tool_choice_type", fmt.Sprint(v))
+		}
+	}
+}
+
+func streamingSanitizeTools(doc map[string]interface{}, profile *SanitizeProfile, report *SanitizationReport) {
+	tools, isArray := doc["tools"].([]interface{})
+	if !isArray {
+		return
+	}
+
+	rw := &schemaRewriter{defs: map[string]interface{}{}, profile: profile, report: report}
+
+	rewriteInPlace := func(container map[string]interface{}, key, path string) {
+		schema, ok := container[key].(map[string]interface{})
+		if !ok {
+			return
+		}
+		rw.defs = collectSchemaDefs(schema)
+		container[key] = rw.rewrite(schema, path, map[string]bool{}, 0)
+	}
+
+	for i, t := range tools {
+		tool, ok := t.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		path := fmt.Sprintf("tools.%d", i)
+
+		fn, hasFunction := tool["function"].(map[string]interface{})
+		if !hasFunction {
+			rewriteInPlace(tool, "input_schema", path+".input_schema")
+			continue
+		}
+
+		rewriteInPlace(fn, "parameters", path+".function.parameters")
+		rewriteInPlace(fn, "input_schema", path+".function.input_schema")
+
+		if _, hasStrict := fn["strict"]; hasStrict {
+			delete(fn, "strict")
+			report.record(path+".function.strict", "unsupported_schema_field", "")
+		}
+	}
+}
+
+func streamingSanitizeMessages(messages []interface{}, profile *SanitizeProfile, report *SanitizationReport) []interface{} {
+	out := make([]interface{}, 0, len(messages))
+
+	for i, m := range messages {
+		msg, ok := m.(map[string]interface{})
+		if !ok {
+			out = append(out, m)
+			continue
+		}
+
+		path := fmt.Sprintf("messages.%d", i)
+		role, _ := msg["role"].(string)
+
+		if profile.RemoveCacheControl {
+			streamingStripCacheControl(msg, path, report)
+		}
+
+		if profile.ConvertClaudeToolUse && role == "assistant" {
+			streamingConvertAssistantToolUse(msg, path, report)
+		}
+		if profile.ConvertClaudeToolUse && role == "user" {
+			streamingConvertToolResult(msg, path, report)
+		}
+
+		if profile.FlattenMessageContent {
+			streamingFlattenContent(msg, path, report)
+		}
+
+		if profile.FixEmptyAssistantMessages && msg["role"] == "assistant" {
+			content := msg["content"]
+			_, hasToolCalls := msg["tool_calls"]
+			contentEmpty := content == nil || content == ""
+			if contentEmpty {
+				if hasToolCalls {
+					msg["content"] = " "
+					report.record(path+".content", "empty_assistant_content_padded", "")
+				} else {
+					report.record(path, "empty_assistant_message_removed", "")
+					continue
+				}
+			}
+		}
+
+		out = append(out, msg)
+	}
+
+	return out
+}
+
+func streamingStripCacheControl(msg map[string]interface{}, path string, report *SanitizationReport) {
+	if _, ok := msg["cache_control"]; ok {
+		delete(msg, "cache_control")
+		report.record(path+".cache_control", "cache_control_unsupported", "")
+	}
+	if _, ok := msg["name"]; ok {
+		delete(msg, "name")
+		report.record(path+".name", "name_unsupported", "")
+	}
+
+	content, isArray := msg["content"].([]interface{})
+	if !isArray {
+		return
+	}
+	for j, item := range content {
+		part, ok := item.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if _, ok := part["cache_control"]; ok {
+			delete(part, "cache_control")
+			report.record(fmt.Sprintf("%s.content.%d.cache_control", path, j), "cache_control_unsupported", "")
+		}
+
+		innerContent, isArray := part["content"].([]interface{})
+		if !isArray {
+			continue
+		}
+		for k, innerItem := range innerContent {
+			innerPart, ok := innerItem.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			if _, ok := innerPart["cache_control"]; ok {
+				delete(innerPart, "cache_control")
+				report.record(fmt.Sprintf("%s.content.%d.content.%d.cache_control", path, j, k), "cache_control_unsupported", "")
+			}
+		}
+	}
+}
+
+func streamingConvertAssistantToolUse(msg map[string]interface{}, path string, report *SanitizationReport) {
+	content, isArray := msg["content"].([]interface{})
+	if !isArray {
+		return
+	}
+
+	var textParts []string
+	var toolCalls []interface{}
+
+	for _, item := range content {
+		part, ok := item.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		switch part["type"] {
+		case "text":
+			if text, _ := part["text"].(string); text != "" {
+				textParts = append(textParts, text)
+			}
+		case "tool_use":
+			toolCalls = append(toolCalls, map[string]interface{}{
+				"id":   part["id"],
+				"type": "function",
+				"function": map[string]interface{}{
+					"name":      part["name"],
+					"arguments": part["input"],
+				},
+			})
+		}
+	}
+
+	if len(toolCalls) == 0 {
+		return
+	}
+
+	contentStr := strings.Join(textParts, "\n")
+	if contentStr == "" {
+		contentStr = " "
+	}
+	msg["content"] = contentStr
+	msg["tool_calls"] = toolCalls
+	report.record(path+".content", "claude_tool_use_converted", "")
+}
+
+func streamingConvertToolResult(msg map[string]interface{}, path string, report *SanitizationReport) {
+	content, isArray := msg["content"].([]interface{})
+	if !isArray {
+		return
+	}
+
+	for _, item := range content {
+		part, ok := item.(map[string]interface{})
+		if !ok || part["type"] != "tool_result" {
+			continue
+		}
+
+		resultContent := streamingExtractToolResultContent(part["content"])
+		if resultContent == "" {
+			resultContent = "{}"
+		}
+
+		msg["role"] = "tool"
+		msg["tool_call_id"] = part["tool_use_id"]
+		msg["content"] = resultContent
+		delete(msg, "tool_use_id")
+		report.record(path, "claude_tool_result_converted", "")
+		return
+	}
+}
+
+func streamingExtractToolResultContent(content interface{}) string {
+	switch v := content.(type) {
+	case string:
+		return v
+	case []interface{}:
+		var textParts []string
+		for _, item := range v {
+			part, ok := item.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			if text, _ := part["text"].(string); part["type"] == "text" && text != "" {
+				textParts = append(textParts, text)
+			}
+		}
+		return strings.Join(textParts, "\n")
+	case map[string]interface{}:
+		if text, ok := v["text"].(string); ok {
+			return text
+		}
+	}
+	return ""
+}
+
+func streamingFlattenContent(msg map[string]interface{}, path string, report *SanitizationReport) {
+	content, isArray := msg["content"].([]interface{})
+	if !isArray {
+		return
+	}
+
+	var textParts []string
+	for _, item := range content {
+		part, ok := item.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if text, _ := part["text"].(string); part["type"] == "text" && text != "" {
+			textParts = append(textParts, text)
+		}
+	}
+
+	if len(textParts) > 0 {
+		msg["content"] = strings.Join(textParts, "")
+		report.record(path+".content", "content_flattened", "")
+		report.FlattenedMessages++
+	}
+}
+
+func streamingMergeSystem(doc map[string]interface{}, report *SanitizationReport) {
+	system, exists := doc["system"]
+	if !exists {
+		return
+	}
+
+	var systemText string
+	switch v := system.(type) {
+	case string:
+		systemText = v
+	case []interface{}:
+		var parts []string
+		for _, item := range v {
+			switch p := item.(type) {
+			case string:
+				parts = append(parts, p)
+			case map[string]interface{}:
+				if p["type"] == "text" {
+					if text, _ := p["text"].(string); text != "" {
+						parts = append(parts, text)
+					}
+				}
+			}
+		}
+		systemText = strings.Join(parts, "\n")
+	}
+
+	defer func() {
+		delete(doc, "system")
+		report.record("system", "system_merged_into_first_user_message", "")
+		report.MergedSystem = true
+	}()
+
+	if systemText == "" {
+		return
+	}
+	report.mergedSystemText = systemText
+
+	messages, isArray := doc["messages"].([]interface{})
+	if !isArray || len(messages) == 0 {
+		return
+	}
+
+	for _, m := range messages {
+		msg, ok := m.(map[string]interface{})
+		if !ok || msg["role"] != "user" {
+			continue
+		}
+
+		switch content := msg["content"].(type) {
+		case string:
+			msg["content"] = "<system>\n" + systemText + "\n</system>\n\n" + content
+		case []interface{}:
+			var textParts []string
+			for _, item := range content {
+				if part, ok := item.(map[string]interface{}); ok && part["type"] == "text" {
+					if text, _ := part["text"].(string); text != "" {
+						textParts = append(textParts, text)
+					}
+				}
+			}
+			msg["content"] = "<system>\n" + systemText + "\n</system>\n\n" + strings.Join(textParts, "")
+		}
+		return
+	}
+}
+
+func streamingSanitizeSystemField(doc map[string]interface{}, report *SanitizationReport) {
+	system, isArray := doc["system"].([]interface{})
+	if !isArray {
+		return
+	}
+
+	for i, item := range system {
+		part, ok := item.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if _, ok := part["cache_control"]; ok {
+			delete(part, "cache_control")
+			report.record(fmt.Sprintf("system.%d.cache_control", i), "cache_control_unsupported", "")
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package middleware
+
+import (
+	"strings"
+	"testing"
+)
+
+// buildBenchmarkPayload returns a request body of roughly targetBytes, shaped like a
+// multimodal chat request: a large base64-ish blob standing in for an inline image or
+// tool_result, plus the root/tool_choice/tools fields a real request would carry, so the
+// benchmark exercises the same fields sanitizeRequestBody and sanitizeRequestBodyStreaming
+// both touch.
+func buildBenchmarkPayload(targetBytes int) []byte {
+	blobSize := targetBytes - 2048
+	if blobSize < 0 {
+		blobSize = 0
+	}
+	blob := strings.Repeat("A", blobSize)
+
+	var b strings.Builder
+	b.WriteString(`{"model":"gemini-1.5-pro","cache_control":{"type":"ephemeral"},"tool_choice":"required",`)
+	b.WriteString(`"tools":[{"type":"function","function":{"name":"lookup","parameters":{"type":"object",`)
+	b.WriteString(`"properties":{"query":{"type":"string","format":"uri","pattern":"^https?://"}},"required":["query"]}}}],`)
+	b.WriteString(`"messages":[`)
+	b.WriteString(`{"role":"user","content":[{"type":"text","text":"describe this image"},`)
+	b.WriteString(`{"type":"image","source":{"type":"base64","data":"`)
+	b.WriteString(blob)
+	b.WriteString(`"}}]},`)
+	b.WriteString(`{"role":"assistant","content":[{"type":"text","text":"sure"}]}`)
+	b.WriteString(`]}`)
+
+	return []byte(b.String())
+}
+
+// BenchmarkSanitize_Pipeline_1MB measures the gjson/sjson multi-pass pipeline on a ~1MB
+// multimodal payload, the path a request below the streaming threshold takes.
+func BenchmarkSanitize_Pipeline_1MB(b *testing.B) {
+	benchmarkPipeline(b, 1<<20)
+}
+
+// BenchmarkSanitize_Streaming_1MB measures the single-pass streaming rewriter on the same
+// ~1MB payload, to compare throughput against the pipeline at a size where either could
+// plausibly run.
+func BenchmarkSanitize_Streaming_1MB(b *testing.B) {
+	benchmarkStreaming(b, 1<<20)
+}
+
+// BenchmarkSanitize_Pipeline_10MB measures the gjson/sjson pipeline on a ~10MB payload,
+// the size class StreamingThresholdBytes exists to route away from it.
+func BenchmarkSanitize_Pipeline_10MB(b *testing.B) {
+	benchmarkPipeline(b, 10<<20)
+}
+
+// BenchmarkSanitize_Streaming_10MB measures the streaming rewriter on a ~10MB payload,
+// the default codepath for bodies this large.
+func BenchmarkSanitize_Streaming_10MB(b *testing.B) {
+	benchmarkStreaming(b, 10<<20)
+}
+
+func benchmarkPipeline(b *testing.B, size int) {
+	payload := buildBenchmarkPayload(size)
+	profile := GeminiProfile()
+
+	b.ReportAllocs()
+	b.SetBytes(int64(len(payload)))
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		sanitizeRequestBody(payload, profile)
+	}
+}
+
+func benchmarkStreaming(b *testing.B, size int) {
+	payload := buildBenchmarkPayload(size)
+	profile := GeminiProfile()
+
+	b.ReportAllocs()
+	b.SetBytes(int64(len(payload)))
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		if _, _, ok := sanitizeRequestBodyStreaming(payload, profile); !ok {
+			b.Fatal("sanitizeRequestBodyStreaming: expected ok=true for a well-formed JSON object")
+		}
+	}
+}